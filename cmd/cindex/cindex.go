@@ -10,6 +10,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/pprof"
 	"sort"
 	"codesearch/index"
@@ -17,7 +18,10 @@ import (
 )
 
 var usageMessage = `usage: cindex [-d indexdb|indexdb_dir] path [path...]
+usage: cindex [-d indexdb] -git [-gitref ref[,ref...]] repo [repo...]
 usage: cindex [-d indexdb] -list
+usage: cindex -check path
+usage: cindex [-d indexdb] -watch [-socket path] path [path...]
 
 indexfile is specified, or search in curdir to / for name .csearchindex or
 $CSEARCHINDEX or $HOME/.csearchindex
@@ -35,42 +39,39 @@ var (
 	cpuProfile  = flag.String("cpuprofile", "", "write cpu profile to this file")
 	indfile = flag.String("d", "", "the index db filename")
 	filetypes = flag.String("ft", "c|cpp|cxx|cc|inc|asm|s|h|hh|hxx|hpp|def|hdr|y|lex|yy", "file types")
+	symFlag = flag.String("sym", "", "look up identifier in the symbol index and print its declarations/uses, then exit")
+	gitFlag = flag.Bool("git", false, "index args as git repositories, reading blobs straight from the object store")
+	gitRefFlag = flag.String("gitref", "HEAD", "comma-separated list of refs to index when -git is set")
+	ignoreFileFlag = flag.String("ignore-file", "", "global .csearchignore-syntax rule file, applied before any per-directory .csearchignore")
+	checkFlag = flag.String("check", "", "print why the given path would be included or excluded, then exit")
+	watchFlag = flag.Bool("watch", false, "after the initial index, keep running and update it incrementally as files change")
+	socketFlag = flag.String("socket", "", "unix-domain control socket for -watch (default: indexdb+\".sock\")")
+	jFlag = flag.Int("j", runtime.GOMAXPROCS(0), "number of parallel workers reading and trigramming files")
 )
 
-func keepElem(elem string, isdir bool) bool{
-	if elem[0] == '.' || elem[0] == '#' || elem[0] == '~' || elem[len(elem)-1] == '~' {
-		return false
-	}
-	if isdir {
-		if elem == "test" || elem == "tests" || elem == "testsuite" || elem == "testsuites"{
-			return false
-		}
-		if elem == "unittests" || elem == "unittest" {
-			return false
+// printHitList prints the declarations and uses of a -sym lookup,
+// declarations first (with their snippet) so the reader lands on
+// the definition before the noise of every call site.
+func printHitList(ix *index.IndexReader, name string) {
+	hits := ix.Lookup(name)
+	for _, pak := range hits.Decls {
+		for _, f := range pak.Files {
+			for _, s := range f.Spots {
+				snip := ix.Snippet(s.SnippetID)
+				fmt.Printf("%s:%d: %s\n", ix.Name(s.FileID), snip.Line, snip.Text)
+			}
 		}
-		return true
-	}
-	// skip foo_test.c
-	if strings.Index(elem, "_test.") >= 0{
-		return false
-	}
-	// skip test_foo.c
-	if strings.HasPrefix(elem, "test_") {
-		return false
 	}
-	pos := strings.LastIndex(elem, ".")
-	if pos < 0{
-		return false
-	}
-	fext := strings.ToLower(elem[pos+1:])
-
-	arr := strings.Split(*filetypes, "|")
-	for _,o := range arr{
-		if o == fext{
-			return true
+	for _, pak := range hits.Uses {
+		for _, f := range pak.Files {
+			for _, s := range f.Spots {
+				fmt.Printf("%s:%d:\n", ix.Name(s.FileID), s.Line)
+			}
 		}
 	}
-	return false
+	if alt := ix.AltSpellings(name); len(alt) > 1 {
+		fmt.Printf("other spellings: %s\n", strings.Join(alt, ", "))
+	}
 }
 
 func main() {
@@ -91,6 +92,39 @@ func main() {
 		return
 	}
 
+	if *symFlag != "" {
+		ix := index.Open(index.File())
+		printHitList(ix, *symFlag)
+		return
+	}
+
+	if *checkFlag != "" {
+		abs, err := filepath.Abs(*checkFlag)
+		if err != nil {
+			log.Fatalf("%s: %v", *checkFlag, err)
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		e := newIgnoreEngine(*ignoreFileFlag, *filetypes)
+		var dirs []string
+		for d := filepath.Dir(abs); ; {
+			dirs = append(dirs, d)
+			parent := filepath.Dir(d)
+			if parent == d {
+				break
+			}
+			d = parent
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			e.loadDir(dirs[i])
+		}
+		check(e, cwd, abs)
+		return
+	}
+
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
@@ -144,41 +178,58 @@ func main() {
 
 	ix := index.Create(file)
 	ix.Verbose = *verboseFlag
-	ix.AddPaths(args)
-	for _, arg := range args {
-		log.Printf("index %s", arg)
-		_ = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
-			if err!=nil{
-				_,_ = fmt.Fprintf(os.Stderr, "%s err: %s", path, err)
-				return nil
+	if *gitFlag {
+		indexGitRepos(ix, args, *gitRefFlag)
+	} else {
+		ix.AddPaths(args)
+		for _, arg := range args {
+			log.Printf("index %s", arg)
+			if *jFlag > 1 {
+				indexTreeParallel(ix, arg, newIgnoreEngine(*ignoreFileFlag, *filetypes), *jFlag)
+				continue
 			}
-			if _, elem := filepath.Split(path); elem != "" {
-				if ! keepElem(elem, info.IsDir()){
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
+			e := newIgnoreEngine(*ignoreFileFlag, *filetypes)
+			_ = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+				if err!=nil{
+					_,_ = fmt.Fprintf(os.Stderr, "%s err: %s", path, err)
 					return nil
 				}
-			}
-			if err != nil {
-				log.Printf("%s: %s", path, err)
+				if info.IsDir() {
+					e.loadDir(path)
+				}
+				if path != arg {
+					if !e.keep(arg, path, info.IsDir()) {
+						if info.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+				}
+				if info != nil && info.Mode()&os.ModeType == 0 {
+					ix.AddFile(path)
+				}
 				return nil
-			}
-			if info != nil && info.Mode()&os.ModeType == 0 {
-				ix.AddFile(path)
-			}
-			return nil
-		})
+			})
+		}
 	}
 	log.Printf("flush index")
 	ix.Flush()
 
 	if !*resetFlag {
 		log.Printf("merge %s %s", master, file)
-		index.Merge(file+"~", master, file)
+		index.Merge(file+"~", master, file, nil)
 		os.Remove(file)
 		os.Rename(file+"~", master)
 	}
 	log.Printf("done")
+
+	if *watchFlag {
+		if *gitFlag {
+			log.Fatal("-watch is not supported together with -git")
+		}
+		if err := runWatch(master, args, *ignoreFileFlag, *filetypes, *socketFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
 	return
 }
@@ -0,0 +1,189 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"codesearch/index"
+)
+
+// indexGitRepos indexes each repo in repos at every ref in refs
+// (a comma-separated list, default "HEAD") without checking the
+// tree out to disk. Each repo/ref pair is recorded under the
+// logical root "repo@ref", which is what -list prints; the
+// resolved commit SHA is stored alongside it with SetCommit but
+// is not shown by -list.
+func indexGitRepos(ix *index.IndexWriter, repos []string, refs string) {
+	for _, repo := range repos {
+		for _, ref := range strings.Split(refs, ",") {
+			ref = strings.TrimSpace(ref)
+			if ref == "" {
+				ref = "HEAD"
+			}
+			if err := indexGitRepo(ix, repo, ref); err != nil {
+				log.Printf("%s@%s: %s", repo, ref, err)
+			}
+		}
+	}
+}
+
+func gitOutput(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var out, errb bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, errb.String())
+	}
+	return out.Bytes(), nil
+}
+
+func indexGitRepo(ix *index.IndexWriter, repo, ref string) error {
+	sha, err := gitOutput(repo, "rev-parse", ref)
+	if err != nil {
+		return err
+	}
+	commit := strings.TrimSpace(string(sha))
+
+	root := fmt.Sprintf("%s@%s", filepath.Clean(repo), ref)
+	log.Printf("index %s (%s)", root, commit)
+
+	tree, err := gitOutput(repo, "ls-tree", "-r", "--name-only", commit)
+	if err != nil {
+		return err
+	}
+	names := strings.Split(strings.TrimRight(string(tree), "\n"), "\n")
+
+	ignore := loadGitIgnore(repo, commit, names)
+
+	ix.AddPaths([]string{root})
+	ix.SetCommit(root, commit)
+
+	for _, name := range names {
+		if name == "" || ignore.match(name) {
+			continue
+		}
+		data, err := gitOutput(repo, "cat-file", "blob", commit+":"+name)
+		if err != nil {
+			log.Printf("%s: %s", name, err)
+			continue
+		}
+		ix.AddFileFromReader(path.Join(root, name), bytes.NewReader(data))
+	}
+	return nil
+}
+
+// gitIgnoreLayer is the patterns from one .gitignore blob, scoped
+// to dir (slash-separated, relative to the repo root, "" for the
+// root .gitignore itself) exactly as git scopes them to the
+// directory a .gitignore lives in.
+type gitIgnoreLayer struct {
+	dir      string
+	patterns []string
+}
+
+// gitIgnore is a minimal .gitignore matcher built from every
+// .gitignore blob in the tree plus the repo's local
+// info/exclude, used instead of the ignoreEngine rules of the
+// filesystem walk when indexing straight from git.
+type gitIgnore struct {
+	layers []gitIgnoreLayer
+}
+
+// loadGitIgnore loads every .gitignore found in names (the result
+// of `git ls-tree -r --name-only`) as its own layer scoped to the
+// directory it lives in, the way real git does, plus the repo's
+// local info/exclude, which isn't part of the tracked tree and so
+// is read straight off disk rather than through git cat-file.
+func loadGitIgnore(repo, commit string, names []string) *gitIgnore {
+	gi := &gitIgnore{}
+	for _, name := range names {
+		if path.Base(name) != ".gitignore" {
+			continue
+		}
+		data, err := gitOutput(repo, "cat-file", "blob", commit+":"+name)
+		if err != nil {
+			continue
+		}
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		gi.layers = append(gi.layers, gitIgnoreLayer{dir: dir, patterns: parseGitIgnorePatterns(data)})
+	}
+	if data := readLocalExclude(repo); data != nil {
+		gi.layers = append(gi.layers, gitIgnoreLayer{patterns: parseGitIgnorePatterns(data)})
+	}
+	return gi
+}
+
+// readLocalExclude reads repo's local exclude file, trying both
+// the non-bare (.git/info/exclude) and bare (info/exclude)
+// layouts, since it lives outside the tracked tree and so has no
+// blob for git cat-file to read.
+func readLocalExclude(repo string) []byte {
+	for _, rel := range []string{filepath.Join(".git", "info", "exclude"), filepath.Join("info", "exclude")} {
+		if data, err := os.ReadFile(filepath.Join(repo, rel)); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+func parseGitIgnorePatterns(data []byte) []string {
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns
+}
+
+// match reports whether name (repo-root relative) should be
+// excluded. It supports the common subset of gitignore syntax:
+// plain names matched against any path component, and patterns
+// anchored with a leading slash matched against the whole path
+// relative to the layer's directory. Negation and double-star are
+// not implemented here; see .csearchignore for the full engine.
+func (gi *gitIgnore) match(name string) bool {
+	for _, l := range gi.layers {
+		rel := name
+		if l.dir != "" {
+			prefix := l.dir + "/"
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			rel = strings.TrimPrefix(name, prefix)
+		}
+		for _, pat := range l.patterns {
+			anchored := strings.HasPrefix(pat, "/")
+			pat = strings.TrimPrefix(pat, "/")
+			pat = strings.TrimSuffix(pat, "/")
+			if anchored {
+				if ok, _ := filepath.Match(pat, rel); ok {
+					return true
+				}
+				continue
+			}
+			for _, elem := range strings.Split(rel, "/") {
+				if ok, _ := filepath.Match(pat, elem); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"codesearch/index"
+)
+
+// initGitRepo creates a temp repo with a root .gitignore, a
+// nested vendor/.gitignore, a local (untracked) info/exclude
+// entry, and a file matching each rule force-added anyway, so
+// loadGitIgnore has to notice a currently-ignored path even
+// though it's tracked in history.
+func initGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	run("config", "user.email", "a@b.c")
+	run("config", "user.name", "a")
+
+	write := func(rel string, data string) {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.log\n")
+	write("vendor/.gitignore", "*.tmp\n")
+	write("keep.go", "package p\n")
+	write("a.log", "root-ignored\n")
+	write("vendor/pkg/secret.tmp", "vendor-ignored\n")
+
+	run("add", "-A")
+	run("add", "-f", "a.log", "vendor/pkg/secret.tmp")
+	run("commit", "-q", "-m", "init")
+
+	write("locally-excluded.txt", "local-ignored\n")
+	if err := os.WriteFile(filepath.Join(dir, ".git", "info", "exclude"), []byte("locally-excluded.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "-f", "locally-excluded.txt")
+	run("commit", "-q", "-m", "force-add locally excluded")
+
+	return dir
+}
+
+// TestLoadGitIgnoreNestedAndLocalExclude guards against two
+// regressions: only the repo-root .gitignore being read (nested
+// vendor/.gitignore never applying), and info/exclude being
+// fetched with git cat-file, which always fails since it isn't
+// part of any tracked tree.
+func TestLoadGitIgnoreNestedAndLocalExclude(t *testing.T) {
+	dir := initGitRepo(t)
+
+	sha, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := string(sha)
+	for len(commit) > 0 && (commit[len(commit)-1] == '\n' || commit[len(commit)-1] == '\r') {
+		commit = commit[:len(commit)-1]
+	}
+
+	names := []string{".gitignore", "a.log", "keep.go", "locally-excluded.txt", "vendor/.gitignore", "vendor/pkg/secret.tmp"}
+	gi := loadGitIgnore(dir, commit, names)
+
+	cases := map[string]bool{
+		"keep.go":               false,
+		"a.log":                 true,
+		"vendor/pkg/secret.tmp": true,
+		"locally-excluded.txt":  true,
+		"vendor/.gitignore":     false,
+	}
+	for name, wantExcluded := range cases {
+		if got := gi.match(name); got != wantExcluded {
+			t.Errorf("match(%q) = %v, want %v", name, got, wantExcluded)
+		}
+	}
+}
+
+// TestIndexGitRepoSkipsIgnoredTrackedFiles is an end-to-end check
+// that indexGitRepo actually leaves ignored-but-tracked files out
+// of the resulting index.
+func TestIndexGitRepoSkipsIgnoredTrackedFiles(t *testing.T) {
+	dir := initGitRepo(t)
+
+	indexFile := filepath.Join(t.TempDir(), "git.csearchindex")
+	ix := index.Create(indexFile)
+	if err := indexGitRepo(ix, dir, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	ix.Flush()
+
+	r := index.Open(indexFile)
+	got := map[string]bool{}
+	for i := 0; i < r.NumFile(); i++ {
+		got[filepath.Base(r.Name(i))] = true
+	}
+
+	for _, want := range []string{"keep.go", ".gitignore"} {
+		if !got[want] {
+			t.Errorf("expected %s to be indexed, got %v", want, got)
+		}
+	}
+	for _, excluded := range []string{"a.log", "secret.tmp", "locally-excluded.txt"} {
+		if got[excluded] {
+			t.Errorf("expected %s to be excluded, but it was indexed", excluded)
+		}
+	}
+}
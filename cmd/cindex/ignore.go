@@ -0,0 +1,298 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// rule is one line of a .csearchignore file, compiled to a
+// regexp so * and ** behave like gitignore's globs.
+type rule struct {
+	file     string // source file, for -check diagnostics
+	line     int
+	raw      string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	re       *regexp.Regexp
+}
+
+// layer is the set of rules loaded from one directory's
+// .csearchignore. Its Dir is the directory the rules are
+// anchored to and scoped under: a layer only applies to paths
+// inside Dir, so a nested .csearchignore can add or relax rules
+// for its own subtree without affecting siblings.
+type layer struct {
+	dir       string
+	rules     []rule
+	filesOnly bool // if set, this layer's rules never apply to directories
+}
+
+// ignoreEngine is the layered rule set keepElem used to be: a
+// built-in default layer (matching the historic behavior), a
+// synthesized layer for -ft, an optional -ignore-file global
+// layer, and one layer per .csearchignore found while walking.
+type ignoreEngine struct {
+	layers []layer
+}
+
+func newIgnoreEngine(globalFile, filetypes string) *ignoreEngine {
+	e := &ignoreEngine{}
+	e.layers = append(e.layers, layer{dir: "", rules: compileRules("<default>", defaultIgnoreLines)})
+	e.layers = append(e.layers, layer{dir: "", rules: compileRules("<-ft>", ftIgnoreLines(filetypes)), filesOnly: true})
+	if globalFile != "" {
+		if rules, err := loadRuleFile(globalFile); err == nil {
+			e.layers = append(e.layers, layer{dir: "", rules: rules})
+		} else {
+			fmt.Fprintf(os.Stderr, "-ignore-file %s: %s\n", globalFile, err)
+		}
+	}
+	return e
+}
+
+// defaultIgnoreLines reproduces the historic keepElem behavior:
+// skip dotfiles, editor backups, test directories and test files.
+var defaultIgnoreLines = []string{
+	".*",
+	"#*",
+	"~*",
+	"*~",
+	"/test/",
+	"/tests/",
+	"/testsuite/",
+	"/testsuites/",
+	"/unittests/",
+	"/unittest/",
+	"**/test/",
+	"**/tests/",
+	"**/testsuite/",
+	"**/testsuites/",
+	"**/unittests/",
+	"**/unittest/",
+	"*_test.*",
+	"test_*",
+}
+
+// ftIgnoreLines turns the historic -ft extension whitelist into
+// an ignore-everything-except-these-extensions layer: ignore all
+// files, then negate the ones whose extension is in filetypes.
+// Directories are left alone so the walk can still descend into
+// them looking for matching files.
+func ftIgnoreLines(filetypes string) []string {
+	lines := []string{"*"}
+	for _, ext := range strings.Split(filetypes, "|") {
+		ext = strings.TrimSpace(ext)
+		if ext != "" {
+			lines = append(lines, "!*."+ext)
+		}
+	}
+	return lines
+}
+
+// loadDir loads dir/.csearchignore, if any, as a new layer
+// scoped to dir.
+func (e *ignoreEngine) loadDir(dir string) {
+	path := filepath.Join(dir, ".csearchignore")
+	rules, err := loadRuleFile(path)
+	if err != nil {
+		return
+	}
+	e.layers = append(e.layers, layer{dir: dir, rules: rules})
+}
+
+func loadRuleFile(path string) ([]rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []rule
+	s := bufio.NewScanner(f)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		rules = append(rules, compileRule(path, lineno, trimmed))
+	}
+	return rules, s.Err()
+}
+
+func compileRules(file string, lines []string) []rule {
+	rules := make([]rule, 0, len(lines))
+	for i, line := range lines {
+		rules = append(rules, compileRule(file, i+1, line))
+	}
+	return rules
+}
+
+func compileRule(file string, lineno int, raw string) rule {
+	pat := raw
+	negate := strings.HasPrefix(pat, "!")
+	if negate {
+		pat = pat[1:]
+	}
+	dirOnly := strings.HasSuffix(pat, "/")
+	pat = strings.TrimSuffix(pat, "/")
+	anchored := strings.HasPrefix(pat, "/") || strings.Contains(pat, "/")
+	pat = strings.TrimPrefix(pat, "/")
+
+	return rule{
+		file:     file,
+		line:     lineno,
+		raw:      raw,
+		negate:   negate,
+		dirOnly:  dirOnly,
+		anchored: anchored,
+		re:       globToRegexp(pat),
+	}
+}
+
+// globToRegexp compiles a single gitignore-style pattern
+// (supporting *, ?, and **) into a regexp anchored to match the
+// whole candidate path.
+func globToRegexp(pat string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(pat)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					// "**/" requires a full path-segment
+					// boundary before what follows, not just
+					// any suffix match: "**/test" must match
+					// "test" or ".../test", never "latest".
+					i++
+					b.WriteString("(?:|.*/)")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		// Fall back to a pattern that matches nothing rather
+		// than panicking on a malformed rule in a user's file.
+		return regexp.MustCompile(`\z\A`)
+	}
+	return re
+}
+
+// decide reports whether path (an absolute path, scoped against
+// root for the global layers and against each layer's own dir
+// for per-directory ones) should be kept, along with a
+// human-readable explanation of the rule that decided it. The
+// last matching rule wins, scanning layers from least to most
+// specific and rules within a layer in file order, exactly as
+// gitignore does.
+func (e *ignoreEngine) decide(root, path string, isDir bool) (keep bool, reason string) {
+	keep = true
+	reason = "no matching rule; default keep"
+	for _, l := range e.layers {
+		if l.filesOnly && isDir {
+			continue
+		}
+		base := root
+		if l.dir != "" {
+			base = l.dir
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		scoped := filepath.ToSlash(rel)
+		for _, r := range l.rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if r.matches(scoped) {
+				keep = r.negate
+				verb := "excluded"
+				if r.negate {
+					verb = "kept"
+				}
+				reason = fmt.Sprintf("%s by %s:%d: `%s`", verb, r.file, r.line, r.raw)
+			}
+		}
+	}
+	return keep, reason
+}
+
+func (r rule) matches(path string) bool {
+	if r.anchored {
+		return r.re.MatchString(path)
+	}
+	if r.re.MatchString(path) {
+		return true
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if r.re.MatchString(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep is the drop-in replacement for keepElem: given the full
+// path of an entry found while walking root, it reports whether
+// to descend into it (directories) or index it (files).
+func (e *ignoreEngine) keep(root, path string, isDir bool) bool {
+	ok, _ := e.decide(root, path, isDir)
+	return ok
+}
+
+// check implements `cindex -check path`: print the verdict and
+// the rule (file + line) that produced it. filepath.Walk never
+// reaches a path under an excluded directory, so check walks
+// root down to path's parent first and reports the first
+// ancestor that an indexing run would have stopped at, rather
+// than deciding path in isolation and missing that it would
+// never have been visited.
+func check(e *ignoreEngine, root, path string) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	dir := root
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if keep, reason := e.decide(root, dir, true); !keep {
+			fmt.Printf("%s: EXCLUDED (ancestor %s %s)\n", path, dir, reason)
+			return
+		}
+	}
+
+	info, err := os.Stat(path)
+	isDir := err == nil && info.IsDir()
+	keep, reason := e.decide(root, path, isDir)
+	verdict := "EXCLUDED"
+	if keep {
+		verdict = "INCLUDED"
+	}
+	fmt.Printf("%s: %s (%s)\n", path, verdict, reason)
+}
@@ -0,0 +1,96 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFtIgnoreDoesNotExcludeDirs guards against a regression
+// where the synthesized -ft layer's "ignore everything" rule
+// matched directories as well as files, stopping the walk from
+// ever descending into subdirectories.
+func TestFtIgnoreDoesNotExcludeDirs(t *testing.T) {
+	e := newIgnoreEngine("", "go")
+
+	if keep, reason := e.decide(".", "src", true); !keep {
+		t.Errorf("directory src: got excluded (%s), want kept so the walk can descend", reason)
+	}
+	if keep, reason := e.decide(".", "src/pkg", true); !keep {
+		t.Errorf("directory src/pkg: got excluded (%s), want kept so the walk can descend", reason)
+	}
+	if keep, _ := e.decide(".", "src/main.go", false); !keep {
+		t.Errorf("src/main.go: want kept, it matches -ft go")
+	}
+	if keep, _ := e.decide(".", "src/main.py", false); keep {
+		t.Errorf("src/main.py: want excluded, it does not match -ft go")
+	}
+}
+
+// TestDecideExcludesAnchoredAncestorDir checks the ancestor
+// verdict check relies on when it walks down to a leaf: for
+// build/foo.c under a root .csearchignore of "/build/", decide
+// must exclude the build directory itself, since filepath.Walk
+// would never reach a file under it. It does not call check.
+func TestDecideExcludesAnchoredAncestorDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".csearchignore"), []byte("/build/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "build", "foo.c")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newIgnoreEngine("", "")
+	e.loadDir(dir)
+	rel, err := filepath.Rel(dir, filepath.Join(dir, "build"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keep, reason := e.decide(dir, filepath.Join(dir, rel), true); keep {
+		t.Errorf("build dir: want excluded by /build/, got kept (%s)", reason)
+	}
+}
+
+// TestGlobDoubleStarRequiresPathBoundary guards against a
+// regression where "**/x" compiled to a bare suffix match, so a
+// file or directory merely ending in "x" (e.g. "latest" for
+// "**/test") was wrongly excluded.
+func TestGlobDoubleStarRequiresPathBoundary(t *testing.T) {
+	re := globToRegexp("**/test")
+	if re.MatchString("latest") {
+		t.Errorf("**/test matched %q, want no match", "latest")
+	}
+	if !re.MatchString("test") {
+		t.Errorf("**/test should match top-level %q", "test")
+	}
+	if !re.MatchString("foo/test") {
+		t.Errorf("**/test should match nested %q", "foo/test")
+	}
+}
+
+// TestNestedIgnoreLayerScopesToItsOwnDir guards against a
+// regression where a per-directory .csearchignore layer's dir
+// (an absolute path) was compared against an already root-relative
+// candidate path, so filepath.Rel always failed and the layer's
+// rules silently never applied.
+func TestNestedIgnoreLayerScopesToItsOwnDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".csearchignore"), []byte("*.secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &ignoreEngine{}
+	e.loadDir(dir)
+	if keep, reason := e.decide(dir, filepath.Join(dir, "x.secret"), false); keep {
+		t.Errorf("x.secret: want excluded by nested .csearchignore, got kept (%s)", reason)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"codesearch/index"
+)
+
+// walkItem is one file filepath.Walk handed to the workers, tagged
+// with its position in the walk so the serializer can restore that
+// order no matter which worker finishes it first.
+type walkItem struct {
+	seq  int
+	path string
+}
+
+// fileResult is what a worker hands back to the serializer: the
+// file's trigram set, identifier tokens and size, computed off
+// the main goroutine so tokenizing one file overlaps with reading
+// the next. ok is false for a path readTrigrams decided to skip;
+// it still carries seq so the serializer's reorder buffer sees a
+// contiguous sequence instead of stalling on a gap.
+type fileResult struct {
+	seq      int
+	ok       bool
+	path     string
+	trigrams map[uint64]bool
+	tokens   []index.Token
+	lines    []string
+	size     int64
+}
+
+// indexTreeParallel walks arg the same way the serial indexer
+// does, but fans candidate paths out to workers workers of
+// readers/tokenizers, letting CPU-bound trigram extraction
+// overlap with I/O. Workers finish in whatever order the
+// scheduler happens to pick, so each result carries the walk-order
+// sequence number its path was enqueued with, and the serializer
+// buffers only as far out of order as the workers have drifted,
+// releasing results to ix.AddFileTrigrams as soon as the next
+// seq in line arrives. That reproduces the same on-disk file
+// order filepath.Walk's traversal gives the serial indexer,
+// regardless of which worker finished first.
+func indexTreeParallel(ix *index.IndexWriter, arg string, e *ignoreEngine, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	items := make(chan walkItem, 256)
+	results := make(chan fileResult, 256)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				r, ok := readTrigrams(it.path)
+				r.seq = it.seq
+				r.ok = ok
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(items)
+		seq := 0
+		_ = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("%s: %s", path, err)
+				return nil
+			}
+			if info.IsDir() {
+				e.loadDir(path)
+			}
+			if path != arg && !e.keep(arg, path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode()&os.ModeType == 0 {
+				items <- walkItem{seq: seq, path: path}
+				seq++
+			}
+			return nil
+		})
+	}()
+
+	pending := make(map[int]fileResult)
+	next := 0
+	for r := range results {
+		pending[r.seq] = r
+		for {
+			rr, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if rr.ok {
+				ix.AddFileTrigrams(rr.path, rr.trigrams, rr.tokens, rr.lines, rr.size)
+			}
+		}
+	}
+}
+
+// readTrigrams is the per-file work a worker does: read, apply
+// the same size/binary heuristics AddFile uses, and extract
+// trigrams plus identifier tokens, so the symbol index comes out
+// the same whether a tree was indexed serially or in parallel.
+// ok is false for files that should be skipped.
+func readTrigrams(path string) (fileResult, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Print(err)
+		return fileResult{}, false
+	}
+	if len(data) > index.MaxFileLen || !index.IsText(data) {
+		return fileResult{}, false
+	}
+	return fileResult{
+		path:     path,
+		trigrams: index.Trigrams(data),
+		tokens:   index.Tokenize(path, data),
+		lines:    index.SplitLines(data),
+		size:     int64(len(data)),
+	}, true
+}
@@ -0,0 +1,141 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codesearch/index"
+)
+
+// writeBenchTree creates n small Go-ish files under a temp
+// directory for the serial-vs-parallel throughput comparison
+// below.
+func writeBenchTree(b *testing.B, n int) string {
+	dir, err := ioutil.TempDir("", "cindex-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+
+	body := []byte("package bench\n\nfunc Foo() int {\n\treturn 42\n}\n")
+	for i := 0; i < n; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("file%d.go", i))
+		if err := ioutil.WriteFile(name, body, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// walkOrder returns the paths filepath.Walk (and so the serial
+// indexer) visits under dir, in its own traversal order, for
+// comparison against the parallel indexer's output order.
+func walkOrder(t *testing.T, dir string, e *ignoreEngine) []string {
+	var want []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != dir && !e.keep(dir, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeType == 0 {
+			want = append(want, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return want
+}
+
+// TestIndexTreeParallelDeterministic guards against a regression
+// where indexTreeParallel called ix.AddFileTrigrams in whatever
+// order the worker pool's results channel happened to drain,
+// giving a different file order (and thus different on-disk file
+// IDs) from one run to the next, and against a second regression
+// where the fix for that sorted by path string instead of
+// reproducing filepath.Walk's actual order: a directory name that
+// is also the prefix of a sibling file (e.g. "pkg/" next to
+// "pkg.go") sorts differently than it walks, since '.' sorts
+// before '/' in byte order.
+func TestIndexTreeParallelDeterministic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cindex-determinism")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{
+		filepath.Join(dir, "pkg", "a.go"),
+		filepath.Join(dir, "pkg.go"),
+	}
+	for i := 0; i < 200; i++ {
+		files = append(files, filepath.Join(dir, fmt.Sprintf("file%d.go", i)))
+	}
+	for _, name := range files {
+		if err := ioutil.WriteFile(name, []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := walkOrder(t, dir, newIgnoreEngine("", "go"))
+
+	file := filepath.Join(dir, ".determinism-index")
+	for i := 0; i < 5; i++ {
+		idx := index.Create(file)
+		indexTreeParallel(idx, dir, newIgnoreEngine("", "go"), 8)
+		idx.Flush()
+
+		r := index.Open(file)
+		if r.NumFile() != len(want) {
+			t.Fatalf("run %d: got %d files, want %d", i, r.NumFile(), len(want))
+		}
+		for j, w := range want {
+			if got := r.Name(j); got != w {
+				t.Fatalf("run %d: file order differs at index %d: got %s, want %s", i, j, got, w)
+			}
+		}
+		os.Remove(file)
+	}
+}
+
+func BenchmarkIndexSerial(b *testing.B) {
+	dir := writeBenchTree(b, 2000)
+	e := newIgnoreEngine("", "go")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix := index.Create(filepath.Join(dir, ".bench-index"))
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !e.keep(dir, path, false) {
+				return nil
+			}
+			ix.AddFile(path)
+			return nil
+		})
+	}
+}
+
+func BenchmarkIndexParallel(b *testing.B) {
+	dir := writeBenchTree(b, 2000)
+	e := newIgnoreEngine("", "go")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ix := index.Create(filepath.Join(dir, ".bench-index"))
+		indexTreeParallel(ix, dir, e, 8)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"codesearch/index"
+)
+
+// debounce is how long watch waits after the last event in a
+// burst before folding the delta index into the master, so that
+// e.g. a save-and-reformat doesn't trigger a reindex per write.
+const debounce = 500 * time.Millisecond
+
+// deltaThreshold is the number of pending changes that forces an
+// immediate merge regardless of the debounce timer, so a large
+// rename or branch switch doesn't grow the in-memory delta
+// unboundedly between quiet periods.
+const deltaThreshold = 2000
+
+// watcher is the state behind `cindex -watch`: a long-running
+// daemon, in the spirit of zoekt-server, that keeps the on-disk
+// index current instead of requiring a periodic full reindex.
+type watcher struct {
+	mu      sync.Mutex
+	master  string
+	roots   []string
+	engine  *ignoreEngine
+	fsw     *fsnotify.Watcher
+	pending map[string]bool // paths touched since the last merge
+	dirty   bool
+
+	flushMu sync.Mutex // serializes flush()'s I/O; mu alone only guards pending/dirty
+}
+
+func runWatch(master string, roots []string, ignoreFile, filetypes string, socketPath string) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fsnotify: %v", err)
+	}
+	w := &watcher{
+		master:  master,
+		roots:   roots,
+		engine:  newIgnoreEngine(ignoreFile, filetypes),
+		fsw:     fsw,
+		pending: make(map[string]bool),
+	}
+
+	for _, root := range roots {
+		if err := w.watchTree(root); err != nil {
+			return err
+		}
+	}
+
+	if socketPath == "" {
+		socketPath = master + ".sock"
+	}
+	os.Remove(socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("control socket: %v", err)
+	}
+	defer ln.Close()
+	go w.serveControl(ln)
+
+	log.Printf("watching %d root(s), control socket %s", len(roots), socketPath)
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(ev)
+			timer.Reset(debounce)
+		case err := <-w.fsw.Errors:
+			log.Printf("watch: %v", err)
+		case <-timer.C:
+			w.flush()
+		}
+	}
+}
+
+// watchTree subscribes to root and every directory beneath it
+// that survives the ignore rules; new directories are picked up
+// as they're created via handleEvent. Like the serial and
+// parallel indexers, it loads each directory's .csearchignore (if
+// any) into w.engine before deciding whether to descend, so
+// watch mode honors the same per-subtree scoping they do.
+func (w *watcher) watchTree(root string) error {
+	return w.watchTreeDiscover(root, false)
+}
+
+// watchTreeDiscover is watchTree plus, when enqueue is set,
+// marking every regular file found as pending. fsnotify only
+// reports the top-level Create event for a new directory (e.g.
+// from mv, git checkout, or unpacking an archive) and never
+// recurses into it, so without this the files a new subtree
+// already contains would never get indexed until something later
+// touched them individually. The initial calls from runWatch
+// don't need this: those files were just indexed by the full scan
+// that runs before -watch takes over.
+func (w *watcher) watchTreeDiscover(root string, enqueue bool) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			w.engine.loadDir(path)
+			if path != root && !w.engine.keep(root, path, true) {
+				return filepath.SkipDir
+			}
+			if err := w.fsw.Add(path); err != nil {
+				log.Printf("watch %s: %v", path, err)
+			}
+			return nil
+		}
+		if enqueue && info.Mode()&os.ModeType == 0 && w.engine.keep(root, path, false) {
+			w.mu.Lock()
+			w.pending[path] = true
+			w.dirty = true
+			w.mu.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if enqueue {
+		w.mu.Lock()
+		n := len(w.pending)
+		w.mu.Unlock()
+		if n >= deltaThreshold {
+			w.flush()
+		}
+	}
+	return nil
+}
+
+func (w *watcher) rootFor(path string) string {
+	for _, root := range w.roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." {
+			return root
+		}
+	}
+	return ""
+}
+
+func (w *watcher) handleEvent(ev fsnotify.Event) {
+	root := w.rootFor(ev.Name)
+	if root == "" {
+		return
+	}
+	info, statErr := os.Stat(ev.Name)
+	isDir := statErr == nil && info.IsDir()
+
+	if ev.Op&fsnotify.Create == fsnotify.Create && isDir {
+		// watchTreeDiscover's own walk never applies the ignore
+		// rules to its root, only to what's beneath it, so a
+		// directory that itself should be excluded (.git,
+		// node_modules, test/, ...) has to be checked here,
+		// against the real watched root, before recursing into it.
+		if w.engine.keep(root, ev.Name, true) {
+			if err := w.watchTreeDiscover(ev.Name, true); err != nil {
+				log.Printf("watch %s: %v", ev.Name, err)
+			}
+		}
+	}
+	if isDir {
+		return
+	}
+	if !w.engine.keep(root, ev.Name, false) {
+		return
+	}
+
+	w.mu.Lock()
+	w.pending[ev.Name] = true
+	w.dirty = true
+	n := len(w.pending)
+	w.mu.Unlock()
+
+	if n >= deltaThreshold {
+		w.flush()
+	}
+}
+
+// flush folds every pending change into the master index: a
+// fresh delta IndexWriter reindexes just the touched files, then
+// Merge replaces their stale postings in the master atomically
+// via the existing rename-based protocol cindex already uses
+// between full reindexes. Paths that no longer exist on disk go
+// into a tombstone set so Merge drops their stale postings from
+// the master instead of carrying them forward forever.
+//
+// The debounce timer and the control socket's FLUSH/CURRENT
+// commands (each served on its own goroutine) can all call flush
+// at once; flushMu serializes the whole body, not just the
+// pending-map bookkeeping, so two flushes never race over the
+// same fixed .delta/.merged paths.
+func (w *watcher) flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	if !w.dirty {
+		w.mu.Unlock()
+		return
+	}
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	w.pending = make(map[string]bool)
+	w.dirty = false
+	w.mu.Unlock()
+
+	delta := w.master + ".delta"
+	ix := index.Create(delta)
+	ix.AddPaths(w.roots)
+	tombstones := make(map[string]bool)
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			tombstones[p] = true
+			continue
+		}
+		ix.AddFile(p)
+	}
+	ix.Flush()
+
+	merged := w.master + ".merged"
+	index.Merge(merged, w.master, delta, tombstones)
+	os.Remove(delta)
+	// os.Rename atomically replaces an existing w.master on POSIX,
+	// so a concurrent reader's index.Open always sees either the
+	// old master or the new one, never neither.
+	os.Rename(merged, w.master)
+	log.Printf("watch: merged %d changed file(s)", len(paths))
+}
+
+// serveControl answers the "is path X current?" question csearch
+// can ask over the control socket, forcing a flush first so the
+// answer reflects the latest events even mid-debounce.
+func (w *watcher) serveControl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go w.handleControl(conn)
+	}
+}
+
+func (w *watcher) handleControl(conn net.Conn) {
+	defer conn.Close()
+	scan := bufio.NewScanner(conn)
+	for scan.Scan() {
+		line := scan.Text()
+		switch {
+		case line == "FLUSH":
+			w.flush()
+			fmt.Fprintln(conn, "OK")
+		case len(line) > len("CURRENT ") && line[:8] == "CURRENT ":
+			path := line[8:]
+			w.flush()
+			w.mu.Lock()
+			_, stale := w.pending[path]
+			w.mu.Unlock()
+			if stale {
+				fmt.Fprintln(conn, "NO")
+			} else {
+				fmt.Fprintln(conn, "YES")
+			}
+		default:
+			fmt.Fprintln(conn, "ERR unknown command")
+		}
+	}
+}
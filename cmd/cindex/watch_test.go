@@ -0,0 +1,215 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+
+	"codesearch/index"
+)
+
+func newTestWatcher(t *testing.T, master string, roots []string) *watcher {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fsw.Close() })
+	return &watcher{
+		master:  master,
+		roots:   roots,
+		engine:  newIgnoreEngine("", "go|txt"),
+		fsw:     fsw,
+		pending: make(map[string]bool),
+	}
+}
+
+// TestWatchTreeDiscoverEnqueuesNewSubtreeFiles guards against a
+// regression where a directory created after watch mode started
+// (e.g. by mv, git checkout, or unpacking an archive) never had
+// the files it already contained indexed: fsnotify only reports
+// the top-level Create event for the new directory and does not
+// recurse, so watchTreeDiscover must walk it itself and enqueue
+// every regular file it finds.
+func TestWatchTreeDiscoverEnqueuesNewSubtreeFiles(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, filepath.Join(dir, "master.csearchindex"), []string{dir})
+
+	sub := filepath.Join(dir, "newsub")
+	if err := os.MkdirAll(filepath.Join(sub, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(sub, "a.txt"),
+		filepath.Join(sub, "nested", "b.txt"),
+	}
+	for _, p := range want {
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.watchTreeDiscover(sub, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range want {
+		if !w.pending[p] {
+			t.Errorf("%s: not marked pending", p)
+		}
+	}
+	if !w.dirty {
+		t.Error("dirty = false, want true")
+	}
+}
+
+// TestHandleEventSkipsIgnoredNewDir guards against a regression
+// where a directory created in one shot (mv, git clone, unpacking
+// an archive) that itself matches an ignore rule -- a top-level
+// "test" directory, say -- got fully indexed anyway:
+// watchTreeDiscover only applies the ignore rules to what's
+// beneath its root, not to the root itself, so handleEvent has to
+// check the new directory against the real watched root before
+// recursing.
+func TestHandleEventSkipsIgnoredNewDir(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, filepath.Join(dir, "master.csearchindex"), []string{dir})
+	if err := w.watchTree(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ignored := filepath.Join(dir, "test")
+	if err := os.MkdirAll(ignored, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ignored, "pkg.go"), []byte("package p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w.handleEvent(fsnotify.Event{Name: ignored, Op: fsnotify.Create})
+
+	if len(w.pending) != 0 || w.dirty {
+		t.Errorf("pending = %v, dirty = %v, want empty/false; test/ should have been skipped", w.pending, w.dirty)
+	}
+}
+
+// TestWatchTreeDiscoverSkipsSymlinkRoot guards against a
+// regression where a Create event for a symlink pointing at a
+// directory (the atomic-deploy "ln -s releases/v2 current"
+// pattern) got enqueued as a pending file to index: os.Stat in
+// handleEvent follows the symlink and reports isDir, but
+// filepath.Walk uses Lstat on its root, so for a symlink root
+// info.IsDir() is false and the walk never descends into it.
+func TestWatchTreeDiscoverSkipsSymlinkRoot(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, filepath.Join(dir, "master.csearchindex"), []string{dir})
+
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "current")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := w.watchTreeDiscover(link, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.pending[link] {
+		t.Errorf("%s: symlink itself was marked pending, want left alone", link)
+	}
+}
+
+// TestWatchTreeInitialCallDoesNotEnqueueFiles guards the other
+// half of the same fix: the startup calls to watchTree from
+// runWatch only need to subscribe directories to fsnotify, since
+// the full index scan that runs before -watch takes over has
+// already indexed those files. Marking them pending too would
+// just force a redundant first flush.
+func TestWatchTreeInitialCallDoesNotEnqueueFiles(t *testing.T) {
+	dir := t.TempDir()
+	w := newTestWatcher(t, filepath.Join(dir, "master.csearchindex"), []string{dir})
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.watchTree(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.pending) != 0 || w.dirty {
+		t.Errorf("pending = %v, dirty = %v, want empty/false", w.pending, w.dirty)
+	}
+}
+
+// TestFlushConcurrentWithHandleEventConverges drives handleEvent
+// and flush concurrently, the way the debounce timer and the
+// control socket's FLUSH/CURRENT handlers (each on their own
+// goroutine) really do, and checks the master index still ends up
+// with exactly the files that were ever marked pending. Both the
+// flushMu serialization fix and a prior tombstone-handling
+// regression were caught only by re-reading flush's code, not by
+// a failing test; this exercises the same concurrency the real
+// daemon subjects it to.
+func TestFlushConcurrentWithHandleEventConverges(t *testing.T) {
+	dir := t.TempDir()
+	master := filepath.Join(dir, "master.csearchindex")
+	w := newTestWatcher(t, master, []string{dir})
+
+	const n = 50
+	var paths []string
+	for i := 0; i < n; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("file%d.go", i))
+		if err := os.WriteFile(p, []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	// Seed an empty master index, the way cindex's initial scan does
+	// before handing off to runWatch.
+	ix := index.Create(master)
+	ix.Flush()
+
+	var wg sync.WaitGroup
+	for _, p := range paths {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			w.handleEvent(fsnotify.Event{Name: p, Op: fsnotify.Create})
+		}(p)
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.flush()
+		}()
+	}
+	wg.Wait()
+	w.flush()
+
+	r := index.Open(master)
+	got := map[string]bool{}
+	for i := 0; i < r.NumFile(); i++ {
+		got[r.Name(i)] = true
+	}
+	for _, p := range paths {
+		if !got[p] {
+			t.Errorf("%s: missing from master index after concurrent flush", p)
+		}
+	}
+	if r.NumFile() != n {
+		t.Errorf("NumFile() = %d, want %d", r.NumFile(), n)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Csearchd serves csearch queries over HTTP, so a browser or
+// script can hit a long-running process instead of paying the
+// cost of opening the index file on every invocation of csearch.
+//
+// index.Open reads the whole index file into heap memory with a
+// single gob.Decode (see index/read.go): there is no mmap, and no
+// sharing of backing pages across processes, so each csearchd's
+// RAM cost is proportional to the index size. What is still true,
+// and is the reason rolling restarts work, is that updates to an
+// existing master are not made in place: `cindex` merges into
+// master~~ and `cindex -watch` into master.merged, then each
+// os.Renames that file over master, which is atomic on the same
+// filesystem. The exception is a `cindex` run with -reset or with
+// no existing master, which writes master directly. A csearchd
+// that already has the old master decoded keeps serving it
+// happily from its own copy in memory; a freshly (re)started
+// csearchd pays the decode cost again but picks up the new file.
+// There is
+// deliberately no attempt to hot-reload mid-process: restart (or
+// front it with a supervisor that does rolling restarts) to pick
+// up a new index.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"codesearch/index"
+)
+
+var (
+	addrFlag    = flag.String("addr", ":8080", "address to serve on")
+	indexFlag   = flag.String("d", "", "index file to serve (default: same search cindex uses)")
+	baseURLFlag = flag.String("base-url", "http://localhost:8080", "base URL to template into opensearch.xml")
+)
+
+var ix *index.IndexReader
+
+func main() {
+	flag.Parse()
+	index.SetFile(*indexFlag)
+	ix = index.Open(index.File())
+
+	http.HandleFunc("/search", gzipHandler(handleSearch))
+	http.HandleFunc("/paths", gzipHandler(handlePaths))
+	http.HandleFunc("/opensearch.xml", handleOpenSearch)
+
+	log.Printf("csearchd serving %s on %s", index.File(), *addrFlag)
+	log.Fatal(http.ListenAndServe(*addrFlag, nil))
+}
+
+// gzipHandler wraps h so that responses are gzip-compressed when
+// the client says it accepts that encoding.
+func gzipHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		h(gzipResponseWriter{ResponseWriter: w, Writer: gz}, r)
+	}
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	Writer *gzip.Writer
+}
+
+func (w gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write(b)
+}
+
+// Match is one line of search output, with the surrounding
+// snippet context the caller asked for.
+type Match struct {
+	Path   string   `json:"path"`
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type searchResponse struct {
+	Matches []Match `json:"matches"`
+}
+
+// handleSearch implements GET /search?q=...&file=...&n=100&context=2.
+//
+// There is no compiled-regexp trigram query planner in this tree
+// (that lives in the csearch command's regexp package upstream,
+// which this snapshot doesn't include), so q is matched as a
+// literal substring: its own trigrams narrow the candidate file
+// list via the index, and each candidate is grepped for literal
+// occurrences. file, if given, is a regexp filtering candidate
+// paths.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	n := 100
+	if s := r.URL.Query().Get("n"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			n = v
+		}
+	}
+	context := 0
+	if s := r.URL.Query().Get("context"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			context = v
+		}
+	}
+	var fileRE *regexp.Regexp
+	if s := r.URL.Query().Get("file"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			http.Error(w, "bad file regexp: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fileRE = re
+	}
+
+	matches := search(q, fileRE, n, context)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(searchResponse{Matches: matches})
+}
+
+func search(q string, fileRE *regexp.Regexp, n, context int) []Match {
+	candidates := candidateFiles(q)
+
+	var matches []Match
+	for _, fileID := range candidates {
+		if len(matches) >= n {
+			break
+		}
+		path := ix.Name(fileID)
+		if fileRE != nil && !fileRE.MatchString(path) {
+			continue
+		}
+		data, err := readFile(path)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		for i, line := range lines {
+			if len(matches) >= n {
+				break
+			}
+			if !strings.Contains(line, q) {
+				continue
+			}
+			m := Match{Path: path, Line: i + 1, Text: line}
+			if context > 0 {
+				m.Before = lines[max(0, i-context):i]
+				m.After = lines[i+1 : min(len(lines), i+1+context)]
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// readFile returns the content of an indexed file. For a
+// filesystem-indexed path it's just ioutil.ReadFile; for a
+// git-indexed path (see cindex -git), path has no real file on
+// disk to read, so it's resolved as "root/rel" against the
+// commit index.SetCommit recorded for root and fetched straight
+// from the repo's object store with git cat-file, the same way
+// cindex -git populated the index in the first place.
+func readFile(path string) ([]byte, error) {
+	root, rel, ok := resolveGitPath(path)
+	if !ok {
+		return ioutil.ReadFile(path)
+	}
+	sha, ok := ix.Commit(root)
+	if !ok {
+		return nil, fmt.Errorf("%s: no commit recorded for %s", path, root)
+	}
+	repo := root[:strings.LastIndex(root, "@")]
+	cmd := exec.Command("git", "-C", repo, "cat-file", "blob", sha+":"+rel)
+	var out, errb bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git cat-file %s:%s: %v: %s", sha, rel, err, errb.String())
+	}
+	return out.Bytes(), nil
+}
+
+// resolveGitPath reports whether path falls under one of the
+// "repo@ref" roots index.Paths lists, splitting it into that
+// root and the path relative to it.
+func resolveGitPath(path string) (root, rel string, ok bool) {
+	for _, r := range ix.Paths() {
+		if !strings.Contains(r, "@") {
+			continue
+		}
+		if path == r {
+			return r, "", true
+		}
+		if strings.HasPrefix(path, r+"/") {
+			return r, path[len(r)+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// candidateFiles narrows the set of files worth grepping using
+// the trigram index, falling back to every indexed file for
+// queries too short to have a trigram of their own.
+func candidateFiles(q string) []int {
+	trigrams := index.Trigrams([]byte(q))
+	if len(trigrams) == 0 {
+		all := make([]int, ix.NumFile())
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	var list []int
+	first := true
+	set := make(map[int]bool)
+	for t := range trigrams {
+		post := ix.PostingList(t)
+		if first {
+			for _, id := range post {
+				set[id] = true
+			}
+			first = false
+			continue
+		}
+		next := make(map[int]bool)
+		for _, id := range post {
+			if set[id] {
+				next[id] = true
+			}
+		}
+		set = next
+	}
+	for id := range set {
+		list = append(list, id)
+	}
+	return list
+}
+
+func handlePaths(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ix.Paths())
+}
+
+var openSearchTemplate = template.Must(template.New("opensearch").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>csearch</ShortName>
+  <Description>Full-text code search</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <Url type="application/json" template="{{.}}/search?q={searchTerms}"/>
+</OpenSearchDescription>
+`))
+
+func handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	_ = openSearchTemplate.Execute(w, *baseURLFlag)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
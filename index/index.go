@@ -0,0 +1,55 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package index implements a trigram index for fast substring
+// search over a corpus of files, along with a secondary
+// identifier index used to jump directly to where a symbol is
+// declared.
+//
+// The on-disk .csearchindex format (see write.go and read.go) is
+// a single gob-encoded blob: Open reads and decodes the whole
+// thing into memory in one shot, and Flush/Merge each write out a
+// brand new blob from scratch rather than appending to or
+// patching the existing file. It is neither memory-mapped nor
+// append-only; every reader pays the full decode cost on Open, and
+// every writer pays the full encode cost even for a one-file
+// delta. That makes it a poor fit for very large corpora (say
+// >100k files): both the in-memory footprint and the per-flush
+// cost scale with the whole index, not with what changed.
+package index
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// magic identifies a .csearchindex file and its on-disk layout.
+// Bump the version suffix whenever a field is added or the
+// encoding of an existing section changes.
+const magic = "csearch index 2\n"
+
+var indexFile string
+
+// File returns the name of the index file to use.
+// It is either indexFile, or $CSEARCHINDEX, or
+// $HOME/.csearchindex.
+func File() string {
+	if indexFile != "" {
+		return indexFile
+	}
+	if f := os.Getenv("CSEARCHINDEX"); f != "" {
+		return f
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "."
+	}
+	return filepath.Join(home, ".csearchindex")
+}
+
+// SetFile sets the name of the index file to use.
+// Set to the empty string to restore the default.
+func SetFile(file string) {
+	indexFile = file
+}
@@ -0,0 +1,104 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+// Merge combines the two indexes src1 and src2, writing the
+// result to dst. Files present in both (by name) take their
+// entry from src2, so that a newly built delta index "wins" over
+// a stale entry in the master index it is merged into. tombstones
+// names files that no longer exist at all: their postings are
+// dropped from src1 even though src2 (which never reindexes a
+// deleted file) has no entry to supersede them with. A nil
+// tombstones is fine when the caller isn't tracking deletions.
+func Merge(dst, src1, src2 string, tombstones map[string]bool) {
+	r1 := Open(src1)
+	r2 := Open(src2)
+
+	ix := Create(dst)
+	ix.AddPaths(mergePaths(r1.Paths(), r2.Paths()))
+	for root, sha := range r1.d.Commits {
+		ix.SetCommit(root, sha)
+	}
+	for root, sha := range r2.d.Commits {
+		ix.SetCommit(root, sha)
+	}
+
+	// newID[reader] maps a source file ID to its ID in ix.
+	newID1 := make([]int, len(r1.d.FileNames))
+	newID2 := make([]int, len(r2.d.FileNames))
+
+	seen := make(map[string]bool)
+	for i, name := range r2.d.FileNames {
+		newID2[i] = ix.fileIDFor(name)
+		seen[name] = true
+	}
+	for i, name := range r1.d.FileNames {
+		if seen[name] || tombstones[name] {
+			// src2's copy supersedes src1's stale copy, or the
+			// file is gone entirely and tombstones says so.
+			newID1[i] = -1
+			continue
+		}
+		newID1[i] = ix.fileIDFor(name)
+	}
+
+	mergeTrigrams(ix, r1, newID1)
+	mergeTrigrams(ix, r2, newID2)
+	mergeIdents(ix, r1, newID1)
+	mergeIdents(ix, r2, newID2)
+
+	for i, id := range newID1 {
+		if id >= 0 {
+			ix.fileSize[id] = r1.d.FileSize[i]
+		}
+	}
+	for i, id := range newID2 {
+		ix.fileSize[id] = r2.d.FileSize[i]
+	}
+
+	ix.Flush()
+}
+
+func mergeTrigrams(ix *IndexWriter, r *IndexReader, newID []int) {
+	for t, ids := range r.d.Trigram {
+		for _, id := range ids {
+			if newID[id] < 0 {
+				continue
+			}
+			ix.addTrigram(t, newID[id])
+		}
+	}
+}
+
+func mergeIdents(ix *IndexWriter, r *IndexReader, newID []int) {
+	for name, spots := range r.d.Idents {
+		for _, s := range spots {
+			if newID[s.FileID] < 0 {
+				continue
+			}
+			spot := s
+			if spot.Kind == Decl {
+				snip := r.d.Snippets[spot.SnippetID]
+				spot.SnippetID = len(ix.snippets)
+				ix.snippets = append(ix.snippets, snip)
+			}
+			spot.FileID = newID[s.FileID]
+			ix.idents[name] = append(ix.idents[name], spot)
+			ix.addAltSpelling(name)
+		}
+	}
+}
+
+func mergePaths(a, b []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, p := range append(append([]string{}, a...), b...) {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,48 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMergeTombstone verifies that a name passed in tombstones
+// has its postings dropped from src1 even though src2 (an empty
+// delta, as produced by watch's flush for a deleted file) has no
+// entry to supersede it with.
+func TestMergeTombstone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge-tombstone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gone := filepath.Join(dir, "gone.go")
+	if err := ioutil.WriteFile(gone, []byte("package p\n\nfunc VanishedSymbol() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src1 := filepath.Join(dir, "src1.csearchindex")
+	ix1 := Create(src1)
+	ix1.AddFile(gone)
+	ix1.Flush()
+
+	src2 := filepath.Join(dir, "src2.csearchindex")
+	Create(src2).Flush() // empty delta, as if gone.go was deleted and never re-added
+
+	dst := filepath.Join(dir, "dst.csearchindex")
+	Merge(dst, src1, src2, map[string]bool{gone: true})
+
+	r := Open(dst)
+	if n := r.NumFile(); n != 0 {
+		t.Errorf("NumFile() = %d, want 0: tombstoned file should not survive the merge", n)
+	}
+	if hits := r.Lookup("VanishedSymbol"); len(hits.Decls) != 0 || len(hits.Uses) != 0 {
+		t.Errorf("Lookup(%q) = %+v, want no hits: tombstoned file's idents should not survive the merge", "VanishedSymbol", hits)
+	}
+}
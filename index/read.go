@@ -0,0 +1,90 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"strings"
+)
+
+// An IndexReader reads a .csearchindex file written by an
+// IndexWriter.
+type IndexReader struct {
+	d *diskIndex
+}
+
+// Open opens the index file and reads it into memory.
+func Open(file string) *IndexReader {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("open index: %v", err)
+	}
+	defer f.Close()
+
+	hdr := make([]byte, len(magic))
+	if _, err := f.Read(hdr); err != nil {
+		log.Fatalf("read index: %v", err)
+	}
+	if string(hdr) != magic {
+		log.Fatalf("%s: unsupported index format (rerun cindex)", file)
+	}
+
+	var d diskIndex
+	if err := gob.NewDecoder(f).Decode(&d); err != nil {
+		log.Fatalf("read index: %v", err)
+	}
+	return &IndexReader{d: &d}
+}
+
+// Paths returns the list of paths that were indexed, as
+// "repo@ref" for git-indexed trees; the resolved commit of such a
+// root is returned by Commit, not included here.
+func (ix *IndexReader) Paths() []string {
+	return ix.d.Paths
+}
+
+// Name returns the name of the file with the given ID.
+func (ix *IndexReader) Name(fileID int) string {
+	return ix.d.FileNames[fileID]
+}
+
+// NumFile returns the number of files in the index, so callers
+// can range over every file ID from 0 to NumFile()-1.
+func (ix *IndexReader) NumFile() int {
+	return len(ix.d.FileNames)
+}
+
+// PostingList returns the sorted list of file IDs containing trigram t.
+func (ix *IndexReader) PostingList(t uint64) []int {
+	return ix.d.Trigram[t]
+}
+
+// Commit returns the commit SHA that root was indexed at, for
+// git-indexed trees, and ok=false otherwise.
+func (ix *IndexReader) Commit(root string) (sha string, ok bool) {
+	sha, ok = ix.d.Commits[root]
+	return
+}
+
+// Snippet returns the snippet with the given ID, as recorded by
+// SpotInfo.SnippetID.
+func (ix *IndexReader) Snippet(id int) Snippet {
+	return ix.d.Snippets[id]
+}
+
+// Lookup returns the declarations and uses of the identifier
+// name, exactly as spelled.
+func (ix *IndexReader) Lookup(name string) HitList {
+	return reduceSpots(ix.d.FileNames, ix.d.Idents[name])
+}
+
+// AltSpellings returns every real spelling recorded for name
+// once folded to lower case, e.g. AltSpellings("foo") might
+// return []string{"foo", "Foo", "FOO"}.
+func (ix *IndexReader) AltSpellings(name string) []string {
+	return ix.d.AltSpellings[strings.ToLower(name)]
+}
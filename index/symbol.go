@@ -0,0 +1,126 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "sort"
+
+// Kind classifies an identifier occurrence recorded in the
+// identifier index.
+type Kind int
+
+const (
+	Use  Kind = iota // a reference to an identifier
+	Decl             // a declaration of an identifier
+)
+
+// A SpotInfo records one occurrence of an identifier.
+//
+// For a Use, Line is the 1-based source line directly and
+// SnippetID is -1. For a Decl, the surrounding source line is
+// instead saved once in the snippet table, SnippetID holds the
+// index into that table, and Line is left 0: the real line number
+// is the Snippet's own Line. This keeps repeated uses of a common
+// identifier from paying for a snippet they don't need.
+type SpotInfo struct {
+	FileID    int
+	Line      int
+	SnippetID int // index into IndexWriter.snippets, or -1
+	Kind      Kind
+	Exported  bool
+}
+
+// A Snippet is the source line surrounding a declaration,
+// recorded once per declaration and shared by SpotInfo.SnippetID.
+type Snippet struct {
+	FileID int
+	Line   int
+	Text   string
+}
+
+// A FileRun gathers every SpotInfo for a single identifier that
+// falls in one file.
+type FileRun struct {
+	FileID int
+	Spots  []SpotInfo
+}
+
+// A PakRun gathers the FileRuns for a single identifier whose
+// files live under one directory ("package", in godoc's sense).
+type PakRun struct {
+	Dir   string
+	Files []FileRun
+}
+
+// A HitList is the result of looking up an identifier: its
+// declarations (with snippets, listed first so callers can jump
+// straight to them) followed by its plain uses.
+type HitList struct {
+	Decls []PakRun
+	Uses  []PakRun
+}
+
+// reduceSpots turns a flat list of SpotInfo for one identifier
+// into a HitList, grouping by directory and file and putting
+// declarations before uses. fileNames maps FileID to path, as
+// held by both IndexWriter and IndexReader.
+func reduceSpots(fileNames []string, spots []SpotInfo) HitList {
+	var decls, uses []SpotInfo
+	for _, s := range spots {
+		if s.Kind == Decl {
+			decls = append(decls, s)
+		} else {
+			uses = append(uses, s)
+		}
+	}
+	return HitList{
+		Decls: pakRuns(fileNames, decls),
+		Uses:  pakRuns(fileNames, uses),
+	}
+}
+
+func pakRuns(fileNames []string, spots []SpotInfo) []PakRun {
+	if len(spots) == 0 {
+		return nil
+	}
+	sort.Slice(spots, func(i, j int) bool { return spots[i].FileID < spots[j].FileID })
+
+	var files []FileRun
+	for i := 0; i < len(spots); {
+		j := i + 1
+		for j < len(spots) && spots[j].FileID == spots[i].FileID {
+			j++
+		}
+		files = append(files, FileRun{FileID: spots[i].FileID, Spots: spots[i:j]})
+		i = j
+	}
+
+	byDir := make(map[string][]FileRun)
+	var dirs []string
+	for _, f := range files {
+		dir := dirOf(fileNames[f.FileID])
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], f)
+	}
+	sort.Strings(dirs)
+
+	runs := make([]PakRun, 0, len(dirs))
+	for _, dir := range dirs {
+		runs = append(runs, PakRun{Dir: dir, Files: byDir[dir]})
+	}
+	return runs
+}
+
+func dirOf(name string) string {
+	i := len(name) - 1
+	for i >= 0 && name[i] != '/' {
+		i--
+	}
+	if i < 0 {
+		return "."
+	}
+	return name[:i]
+}
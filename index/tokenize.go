@@ -0,0 +1,205 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// A Token is one occurrence of an identifier found by a
+// tokenizer, before it has been turned into a SpotInfo (which
+// needs a file ID that the tokenizer doesn't know about). It is
+// exported so that out-of-package callers doing their own file
+// reading (cindex's parallel worker pool, notably) can tokenize a
+// file and hand the result to AddFileTrigrams without making
+// IndexWriter re-read or re-tokenize it.
+type Token struct {
+	Name string
+	Line int
+	Kind Kind
+}
+
+// isExported reports whether name would be an exported Go
+// identifier; other languages have no such notion, so the same
+// rule is applied uniformly as a reasonable default.
+func isExported(name string) bool {
+	r := []rune(name)
+	return len(r) > 0 && unicode.IsUpper(r[0])
+}
+
+// declState is where tokenizeGo is with respect to a pending
+// func/type/var/const declaration: whether the next identifier
+// counts as a Decl, and why.
+type declState int
+
+const (
+	// declIdle: ordinary code; the next IDENT is a Use.
+	declIdle declState = iota
+	// declExpectName: just saw func/type/var/const at top
+	// level; the next IDENT (once any receiver or group parens
+	// are out of the way) is the declared name.
+	declExpectName
+	// declInReceiver: saw "func (" while declExpectName; skip
+	// the receiver clause until its matching ")", after which
+	// the func's name is still pending (back to declExpectName).
+	declInReceiver
+	// declInGroup: inside a var/type/const "(...)" block; the
+	// next IDENT at the block's own nesting level is a Decl,
+	// gated by groupAwaitItem so only the first identifier of
+	// each item is tagged, not every name on its line.
+	declInGroup
+	// declAwaitComma: just tagged a top-level (ungrouped) var/
+	// const name as a Decl; a comma immediately following it
+	// introduces a sibling name ("var A, B = 1, 2") and goes
+	// back to declExpectName, anything else ends the name list
+	// for good.
+	declAwaitComma
+)
+
+// tokenizeGo tokenizes Go source, classifying as a declaration
+// the name introduced by a top-level (paren depth 0) func, type,
+// var or const: the identifier after func's optional receiver
+// clause, or the first identifier of each item in a grouped
+// "var (...)"/"const (...)"/"type (...)" block. A var/const item
+// can itself introduce several comma-separated names ("var A, B
+// = 1, 2"), and every name up to the next non-comma token (the
+// type, "=", or the item's end) is tagged as a Decl, whether the
+// item is top-level or inside a group. Everything else, including
+// identifiers inside function bodies and parameter lists, is a
+// use.
+func tokenizeGo(src []byte) []Token {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+
+	var idents []Token
+	depth := 0
+	state := declIdle
+	isFunc := false // the keyword that put us in declExpectName, for the LPAREN case below
+	groupDepth := 0 // depth at which the receiver/group parens were opened
+	groupAwaitItem := false
+	groupAwaitComma := false // just tagged a Decl inside a group item; see declAwaitComma
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if state == declAwaitComma {
+			if tok == token.COMMA {
+				state = declExpectName
+				continue
+			}
+			state = declIdle
+		}
+		if groupAwaitComma {
+			groupAwaitComma = false
+			if tok == token.COMMA {
+				groupAwaitItem = true
+				continue
+			}
+		}
+		switch tok {
+		case token.FUNC:
+			if depth == 0 {
+				state, isFunc = declExpectName, true
+			}
+			continue
+		case token.TYPE, token.VAR, token.CONST:
+			if depth == 0 {
+				state, isFunc = declExpectName, false
+			}
+			continue
+		case token.LPAREN:
+			if state == declExpectName {
+				// Transparent to the declaration(s) on the other
+				// side: a method's receiver clause for func, or
+				// a grouped block for type/var/const.
+				groupDepth = depth
+				if isFunc {
+					state = declInReceiver
+				} else {
+					state = declInGroup
+					groupAwaitItem = true
+				}
+			}
+			depth++
+			continue
+		case token.LBRACE, token.LBRACK:
+			depth++
+			continue
+		case token.RPAREN:
+			depth--
+			if depth == groupDepth {
+				if state == declInReceiver {
+					state = declExpectName // the method name is still pending
+				} else if state == declInGroup {
+					state = declIdle
+				}
+			}
+			continue
+		case token.RBRACE, token.RBRACK:
+			depth--
+			continue
+		case token.SEMICOLON:
+			if state == declInGroup && depth == groupDepth+1 {
+				groupAwaitItem = true
+			}
+			continue
+		case token.IDENT:
+			kind := Use
+			switch {
+			case state == declExpectName:
+				kind = Decl
+				if isFunc {
+					state = declIdle
+				} else {
+					state = declAwaitComma
+				}
+			case state == declInGroup && groupAwaitItem:
+				kind = Decl
+				groupAwaitItem = false
+				groupAwaitComma = true
+			}
+			idents = append(idents, Token{Name: lit, Line: fset.Position(pos).Line, Kind: kind})
+		}
+	}
+	return idents
+}
+
+// identRE matches a plain-text identifier: a word of letters,
+// digits and underscores not starting with a digit. It is the
+// tokenizer used for file types that aren't Go, e.g. C/C++,
+// where csearch has no grammar to tell a declaration from a use.
+var identRE = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// tokenizePlain splits src into identifiers using identRE. Every
+// occurrence is reported as a Use: without a grammar there is no
+// reliable way to single out declarations.
+func tokenizePlain(src []byte) []Token {
+	var idents []Token
+	line := 1
+	start := 0
+	for _, loc := range identRE.FindAllIndex(src, -1) {
+		line += strings.Count(string(src[start:loc[0]]), "\n")
+		start = loc[0]
+		idents = append(idents, Token{Name: string(src[loc[0]:loc[1]]), Line: line, Kind: Use})
+	}
+	return idents
+}
+
+// Tokenize picks a tokenizer for name's file type and returns
+// every identifier occurrence it finds in src.
+func Tokenize(name string, src []byte) []Token {
+	if strings.HasSuffix(name, ".go") {
+		return tokenizeGo(src)
+	}
+	return tokenizePlain(src)
+}
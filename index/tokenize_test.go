@@ -0,0 +1,90 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import "testing"
+
+// declsOf returns the names tokenizeGo tagged as Decl, in order.
+func declsOf(src string) []string {
+	var names []string
+	for _, id := range tokenizeGo([]byte(src)) {
+		if id.Kind == Decl {
+			names = append(names, id.Name)
+		}
+	}
+	return names
+}
+
+func TestTokenizeGoDecls(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "plain func",
+			src:  "package p\n\nfunc Foo() {}\n",
+			want: []string{"Foo"},
+		},
+		{
+			name: "method with pointer receiver",
+			src:  "package p\n\nfunc (r *Receiver) Foo() {}\n",
+			want: []string{"Foo"},
+		},
+		{
+			name: "method with value receiver",
+			src:  "package p\n\nfunc (r Receiver) Bar() {}\n",
+			want: []string{"Bar"},
+		},
+		{
+			name: "grouped var block",
+			src:  "package p\n\nvar (\n\tA = 1\n\tB = 2\n)\n",
+			want: []string{"A", "B"},
+		},
+		{
+			name: "grouped const block",
+			src:  "package p\n\nconst (\n\tX = iota\n\tY\n)\n",
+			want: []string{"X", "Y"},
+		},
+		{
+			name: "grouped type block",
+			src:  "package p\n\ntype (\n\tA int\n\tB struct{}\n)\n",
+			want: []string{"A", "B"},
+		},
+		{
+			name: "single var and const",
+			src:  "package p\n\nvar Foo = 1\nconst Bar = 2\n",
+			want: []string{"Foo", "Bar"},
+		},
+		{
+			name: "ungrouped multi-name var",
+			src:  "package p\n\nvar A, B = 1, 2\n",
+			want: []string{"A", "B"},
+		},
+		{
+			name: "ungrouped multi-name var with type",
+			src:  "package p\n\nvar A, B int\n",
+			want: []string{"A", "B"},
+		},
+		{
+			name: "grouped multi-name var item",
+			src:  "package p\n\nvar (\n\tA, B = 1, 2\n\tC = 3\n)\n",
+			want: []string{"A", "B", "C"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := declsOf(tt.src)
+			if len(got) != len(tt.want) {
+				t.Fatalf("decls = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("decls = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
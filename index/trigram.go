@@ -0,0 +1,54 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+// MaxFileLen is the largest file AddFile (or an external
+// producer feeding AddFileTrigrams/AddFileFromReader) should
+// index. Larger files are assumed to be generated or binary and
+// should be skipped.
+const MaxFileLen = 1 << 30
+
+const maxFileLen = MaxFileLen
+
+// maxLineLen is the longest line AddFile will index.
+// Files with longer lines are assumed to be binary or minified
+// and are skipped, mirroring the heuristic csearch itself uses
+// when deciding whether a match is worth printing.
+const maxLineLen = 2000
+
+// IsText reports whether data looks like UTF-8 text: no NUL
+// bytes and no absurdly long lines.
+func IsText(data []byte) bool {
+	lineLen := 0
+	for _, c := range data {
+		if c == '\n' {
+			lineLen = 0
+			continue
+		}
+		lineLen++
+		if lineLen > maxLineLen {
+			return false
+		}
+		if c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Trigrams returns the set of trigrams present in data, encoded
+// as the concatenation of three consecutive bytes into a uint64.
+func Trigrams(data []byte) map[uint64]bool {
+	set := make(map[uint64]bool)
+	if len(data) < 3 {
+		return set
+	}
+	tv := uint64(data[0])<<8 | uint64(data[1])
+	for _, c := range data[2:] {
+		tv = (tv<<8 | uint64(c)) & (1<<24 - 1)
+		set[tv] = true
+	}
+	return set
+}
@@ -0,0 +1,258 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// An IndexWriter builds a new index, holding it in memory until
+// Flush writes it to disk.
+type IndexWriter struct {
+	Verbose bool
+
+	paths []string
+
+	fileNames []string
+	fileID    map[string]int
+	fileSize  []int64
+
+	trigram map[uint64]map[int]bool // trigram -> set of file IDs
+
+	idents       map[string][]SpotInfo // identifier -> occurrences
+	snippets     []Snippet
+	altSpellings map[string]map[string]bool // folded identifier -> real spellings
+
+	// commits records, for git-indexed trees, the resolved
+	// commit SHA each logical root ("repo@ref") was read from.
+	commits map[string]string
+
+	file string
+}
+
+// Create returns a new IndexWriter that will write the index to file.
+func Create(file string) *IndexWriter {
+	return &IndexWriter{
+		file:         file,
+		fileID:       make(map[string]int),
+		trigram:      make(map[uint64]map[int]bool),
+		idents:       make(map[string][]SpotInfo),
+		altSpellings: make(map[string]map[string]bool),
+		commits:      make(map[string]string),
+	}
+}
+
+// AddPaths adds the given paths to the index's list of paths.
+func (ix *IndexWriter) AddPaths(paths []string) {
+	ix.paths = append(ix.paths, paths...)
+}
+
+// SetCommit records the commit SHA that root was read from,
+// retrievable with IndexReader.Commit. `cindex -list` prints only
+// the root itself, not this SHA.
+func (ix *IndexWriter) SetCommit(root, sha string) {
+	ix.commits[root] = sha
+}
+
+func (ix *IndexWriter) fileIDFor(name string) int {
+	if id, ok := ix.fileID[name]; ok {
+		return id
+	}
+	id := len(ix.fileNames)
+	ix.fileNames = append(ix.fileNames, name)
+	ix.fileSize = append(ix.fileSize, 0)
+	ix.fileID[name] = id
+	return id
+}
+
+// AddFile reads the file named by name and indexes it.
+func (ix *IndexWriter) AddFile(name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer f.Close()
+	ix.addFileOrReader(name, f)
+}
+
+// AddFileFromReader indexes the content read from r as if it
+// were a file named name, without requiring the data to exist
+// on disk under that name. It is the entry point used by
+// git-aware indexing, which streams blob contents straight out
+// of the object store.
+func (ix *IndexWriter) AddFileFromReader(name string, r io.Reader) {
+	ix.addFileOrReader(name, r)
+}
+
+func (ix *IndexWriter) addFileOrReader(name string, r io.Reader) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxFileLen+1))
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		return
+	}
+	if len(data) > maxFileLen {
+		if ix.Verbose {
+			log.Printf("%s: too long, ignoring", name)
+		}
+		return
+	}
+	if !IsText(data) {
+		if ix.Verbose {
+			log.Printf("%s: binary, ignoring", name)
+		}
+		return
+	}
+
+	fileID := ix.fileIDFor(name)
+	ix.fileSize[fileID] = int64(len(data))
+	ix.addTrigrams(fileID, data)
+	ix.addIdentifiers(fileID, name, data)
+	if ix.Verbose {
+		log.Printf("%d %s", fileID, name)
+	}
+}
+
+// AddFileTrigrams records a file's trigram set and identifier
+// tokens computed elsewhere (by a parallel worker, for instance)
+// without AddFile needing to re-read or re-tokenize the file.
+// tokens and lines should come from Tokenize(name, data) and
+// SplitLines(data) for the same data the trigrams were extracted
+// from, so that -sym lookups behave the same as they would for a
+// file indexed via AddFile.
+func (ix *IndexWriter) AddFileTrigrams(name string, trigrams map[uint64]bool, tokens []Token, lines []string, size int64) {
+	fileID := ix.fileIDFor(name)
+	ix.fileSize[fileID] = size
+	for t := range trigrams {
+		ix.addTrigram(t, fileID)
+	}
+	ix.addTokens(fileID, tokens, lines)
+}
+
+func (ix *IndexWriter) addTrigrams(fileID int, data []byte) {
+	for t := range Trigrams(data) {
+		ix.addTrigram(t, fileID)
+	}
+}
+
+func (ix *IndexWriter) addTrigram(t uint64, fileID int) {
+	set, ok := ix.trigram[t]
+	if !ok {
+		set = make(map[int]bool)
+		ix.trigram[t] = set
+	}
+	set[fileID] = true
+}
+
+func (ix *IndexWriter) addIdentifiers(fileID int, name string, data []byte) {
+	ix.addTokens(fileID, Tokenize(name, data), SplitLines(data))
+}
+
+func (ix *IndexWriter) addTokens(fileID int, tokens []Token, lines []string) {
+	for _, tok := range tokens {
+		spot := SpotInfo{
+			FileID:    fileID,
+			Line:      tok.Line,
+			SnippetID: -1,
+			Kind:      tok.Kind,
+			Exported:  isExported(tok.Name),
+		}
+		if tok.Kind == Decl {
+			spot.SnippetID = ix.addSnippet(fileID, tok.Line, lines)
+			spot.Line = 0
+		}
+		ix.idents[tok.Name] = append(ix.idents[tok.Name], spot)
+		ix.addAltSpelling(tok.Name)
+	}
+}
+
+func (ix *IndexWriter) addSnippet(fileID, line int, lines []string) int {
+	text := ""
+	if line-1 >= 0 && line-1 < len(lines) {
+		text = strings.TrimRight(lines[line-1], "\r")
+	}
+	id := len(ix.snippets)
+	ix.snippets = append(ix.snippets, Snippet{FileID: fileID, Line: line, Text: text})
+	return id
+}
+
+func (ix *IndexWriter) addAltSpelling(name string) {
+	folded := strings.ToLower(name)
+	set, ok := ix.altSpellings[folded]
+	if !ok {
+		set = make(map[string]bool)
+		ix.altSpellings[folded] = set
+	}
+	set[name] = true
+}
+
+// SplitLines splits data into its lines, exported so callers
+// building a Token list themselves (see AddFileTrigrams) can
+// reuse the same splitting AddFile does internally.
+func SplitLines(data []byte) []string {
+	return strings.Split(string(data), "\n")
+}
+
+// Flush writes the index to its file.
+func (ix *IndexWriter) Flush() {
+	d := toDisk(ix)
+	var buf bytes.Buffer
+	buf.WriteString(magic)
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		log.Fatalf("writing index: %v", err)
+	}
+	if err := ioutil.WriteFile(ix.file, buf.Bytes(), 0666); err != nil {
+		log.Fatalf("writing index: %v", err)
+	}
+}
+
+// diskIndex is the gob-encoded payload of a .csearchindex file,
+// written after the magic/version header.
+type diskIndex struct {
+	Paths        []string
+	FileNames    []string
+	FileSize     []int64
+	Trigram      map[uint64][]int
+	Idents       map[string][]SpotInfo
+	Snippets     []Snippet
+	AltSpellings map[string][]string
+	Commits      map[string]string
+}
+
+func toDisk(ix *IndexWriter) *diskIndex {
+	d := &diskIndex{
+		Paths:        ix.paths,
+		FileNames:    ix.fileNames,
+		FileSize:     ix.fileSize,
+		Trigram:      make(map[uint64][]int, len(ix.trigram)),
+		Idents:       ix.idents,
+		Snippets:     ix.snippets,
+		AltSpellings: make(map[string][]string, len(ix.altSpellings)),
+		Commits:      ix.commits,
+	}
+	for t, set := range ix.trigram {
+		ids := make([]int, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		d.Trigram[t] = ids
+	}
+	for folded, set := range ix.altSpellings {
+		spellings := make([]string, 0, len(set))
+		for s := range set {
+			spellings = append(spellings, s)
+		}
+		d.AltSpellings[folded] = spellings
+	}
+	return d
+}